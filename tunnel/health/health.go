@@ -0,0 +1,159 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package health tracks the current failure state of the components in
+// this package's parent, tunnel: Proxyguard spawning, default-route
+// proxy routing, and per-interface/per-peer MTU discovery. Each
+// component reports into a shared registry of named "warnables" instead
+// of only logging, so the current reason a tunnel isn't fully healthy
+// can be queried at any time, e.g. over UAPI's get=health command.
+package health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity classifies how serious a warnable's current state is.
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Names of the warnables reported by this chunk of tunnel. Components
+// outside this package may still register their own, but using these
+// constants keeps names consistent between reporters and the UI.
+const (
+	ProxyNotReady          = "proxy-not-ready"
+	NoDefaultRouteV4       = "no-default-route-v4"
+	NoDefaultRouteV6       = "no-default-route-v6"
+	RouteAddFailed         = "route-add-failed"
+	MTUClampedBelowFloorV4 = "mtu-clamped-below-576"
+	MTUClampedBelowFloorV6 = "mtu-clamped-below-1280"
+	InterfaceDown          = "interface-down"
+)
+
+type state struct {
+	severity Severity
+	message  string
+	since    time.Time
+}
+
+// Entry is a point-in-time snapshot of one warnable's state.
+type Entry struct {
+	Name     string
+	Severity Severity
+	Message  string
+	Since    time.Time
+	Duration time.Duration
+}
+
+// Tracker is a registry of named warnables. The zero value is not usable;
+// construct one with NewTracker. It is safe for concurrent use.
+type Tracker struct {
+	mu    sync.RWMutex
+	warns map[string]*state
+}
+
+// NewTracker returns an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{warns: make(map[string]*state)}
+}
+
+// Set marks name as currently failing with the given severity and
+// message. If name was already set, its message and severity are
+// updated but its since timestamp, and therefore its time-in-state, is
+// preserved - flapping between two messages for the same underlying
+// problem should not reset the clock.
+func (t *Tracker) Set(name string, severity Severity, message string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.warns[name]; ok {
+		s.severity = severity
+		s.message = message
+		return
+	}
+	t.warns[name] = &state{severity: severity, message: message, since: time.Now()}
+}
+
+// Clear marks name as no longer failing. Clearing a name that was never
+// set, or is already clear, is a no-op.
+func (t *Tracker) Clear(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.warns, name)
+}
+
+// Snapshot returns the current state of every set warnable, sorted by
+// name for stable output.
+func (t *Tracker) Snapshot() []Entry {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	entries := make([]Entry, 0, len(t.warns))
+	now := time.Now()
+	for name, s := range t.warns {
+		entries = append(entries, Entry{
+			Name:     name,
+			Severity: s.severity,
+			Message:  s.message,
+			Since:    s.since,
+			Duration: now.Sub(s.since),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// FormatUAPI renders the current snapshot as UAPI response lines,
+// suitable for appending to the reply to a get=health command on the
+// named-pipe UAPI: each warnable becomes four "key=value\n" lines -
+// warnable, severity, message and since - mirroring the way UAPI already
+// reports one key=value pair per line for everything else, rather than
+// packing a whole warnable onto a single delimited line. An empty
+// snapshot renders as no lines at all.
+func (t *Tracker) FormatUAPI() string {
+	entries := t.Snapshot()
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "warnable=%s\n", e.Name)
+		fmt.Fprintf(&b, "severity=%s\n", e.Severity)
+		fmt.Fprintf(&b, "message=%s\n", escapeUAPIValue(e.Message))
+		fmt.Fprintf(&b, "since=%d\n", e.Since.Unix())
+	}
+	return b.String()
+}
+
+// escapeUAPIValue replaces newlines in a value bound for a single UAPI
+// key=value line with spaces, since message is often err.Error() and an
+// unescaped newline there would be indistinguishable from the start of
+// the next line's key.
+func escapeUAPIValue(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", " "), "\n", " ")
+}
+
+// Default is the process-wide health tracker used by the tunnel package.
+// It mirrors the existing convention of a single global Proxyguard
+// logger (see proxy.go's init) rather than threading a Tracker through
+// every function signature in this chunk.
+var Default = NewTracker()
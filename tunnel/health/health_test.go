@@ -0,0 +1,118 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package health
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrackerSetPreservesSinceAcrossFlapping(t *testing.T) {
+	tr := NewTracker()
+	tr.Set("x", Warning, "first message")
+	before := tr.Snapshot()[0].Since
+
+	tr.Set("x", Error, "second message")
+	entries := tr.Snapshot()
+	if len(entries) != 1 {
+		t.Fatalf("Snapshot returned %d entries, want 1", len(entries))
+	}
+	if entries[0].Severity != Error {
+		t.Errorf("Severity = %v, want Error", entries[0].Severity)
+	}
+	if entries[0].Message != "second message" {
+		t.Errorf("Message = %q, want %q", entries[0].Message, "second message")
+	}
+	if !entries[0].Since.Equal(before) {
+		t.Errorf("Since changed across a Set on an already-set warnable: %v != %v", entries[0].Since, before)
+	}
+}
+
+func TestTrackerClear(t *testing.T) {
+	tr := NewTracker()
+	tr.Set("x", Warning, "msg")
+	tr.Clear("x")
+	if entries := tr.Snapshot(); len(entries) != 0 {
+		t.Errorf("Snapshot after Clear = %v, want empty", entries)
+	}
+	// Clearing something never set, or already clear, is a no-op.
+	tr.Clear("x")
+	tr.Clear("never-set")
+}
+
+func TestTrackerSnapshotSortedByName(t *testing.T) {
+	tr := NewTracker()
+	tr.Set("zebra", Info, "z")
+	tr.Set("alpha", Info, "a")
+	tr.Set("mike", Info, "m")
+
+	entries := tr.Snapshot()
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name
+	}
+	want := []string{"alpha", "mike", "zebra"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("Snapshot order = %v, want %v", names, want)
+		}
+	}
+}
+
+func TestFormatUAPI(t *testing.T) {
+	tr := NewTracker()
+	tr.Set("interface-down", Error, "tunnel interface is not up")
+
+	out := tr.FormatUAPI()
+	for _, want := range []string{
+		"warnable=interface-down\n",
+		"severity=error\n",
+		"message=tunnel interface is not up\n",
+		"since=",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("FormatUAPI output missing %q; got:\n%s", want, out)
+		}
+	}
+}
+
+func TestFormatUAPIEmptyTracker(t *testing.T) {
+	tr := NewTracker()
+	if out := tr.FormatUAPI(); out != "" {
+		t.Errorf("FormatUAPI on an empty Tracker = %q, want empty string", out)
+	}
+}
+
+func TestEscapeUAPIValue(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"no newlines here", "no newlines here"},
+		{"line one\nline two", "line one line two"},
+		{"windows\r\nstyle", "windows style"},
+		{"trailing\n", "trailing "},
+	}
+	for _, c := range cases {
+		if got := escapeUAPIValue(c.in); got != c.want {
+			t.Errorf("escapeUAPIValue(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestSeverityString(t *testing.T) {
+	cases := []struct {
+		s    Severity
+		want string
+	}{
+		{Info, "info"},
+		{Warning, "warning"},
+		{Error, "error"},
+		{Severity(99), "unknown"},
+	}
+	for _, c := range cases {
+		if got := c.s.String(); got != c.want {
+			t.Errorf("Severity(%d).String() = %q, want %q", c.s, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"io"
+
+	"golang.zx2c4.com/wireguard/windows/tunnel/health"
+)
+
+// AppendHealthUAPI writes the current health snapshot to w as get=health
+// response lines. The named-pipe UAPI server appends these after the
+// lines it gets from the device itself, so that a get=health request
+// surfaces this package's warnables (proxy, route and MTU state)
+// alongside the usual device/peer statistics - and, from there, the
+// manager UI can render them without the rest of the UAPI protocol
+// needing to know anything about health.
+func AppendHealthUAPI(w io.Writer) error {
+	_, err := io.WriteString(w, health.Default.FormatUAPI())
+	return err
+}
@@ -0,0 +1,28 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package metrics
+
+// The metrics emitted by tunnel's route, proxy and MTU monitors. Label
+// values for "family" are "ipv4" or "ipv6"; for "result", "ok" or
+// "error"; "listen" is a proxy's local listen address, matching the
+// peer's configured Endpoint.
+var (
+	RouteAddTotal = NewCounterVec("wg_route_add_total",
+		"Total number of attempts to add a proxy route, by result.", "family", "result")
+	RouteDeleteTotal = NewCounterVec("wg_route_delete_total",
+		"Total number of attempts to delete a proxy route, by result.", "family", "result")
+	ProxyRestartTotal = NewCounterVec("wg_proxy_restart_total",
+		"Total number of times a peer proxy was signaled to restart.", "listen")
+	DefaultRouteChangeTotal = NewCounterVec("wg_default_route_change_total",
+		"Total number of observed changes to the foreign default route.", "family")
+
+	TunnelMTUBytes = NewGaugeVec("wg_tunnel_mtu_bytes",
+		"Current tunnel interface MTU, in bytes.", "family")
+	DefaultRouteMetric = NewGaugeVec("wg_default_route_metric",
+		"Effective metric of the foreign default route currently in use.", "family")
+	ProxyReady = NewGaugeVec("wg_proxy_ready",
+		"1 if a peer proxy is ready and relaying traffic, 0 otherwise.", "listen")
+)
@@ -0,0 +1,177 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package metrics is a minimal Prometheus text-exposition-format
+// registry for the route, proxy and MTU counters/gauges this chunk of
+// tunnel emits. It intentionally does not pull in the full
+// client_golang dependency tree; a handful of labeled counters and
+// gauges rendered as plain text is all that's needed here, and it is
+// exposed only when explicitly enabled (see Enabled in server.go).
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CounterVec is a monotonically increasing counter partitioned by a
+// fixed set of label names.
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// NewCounterVec registers and returns a new counter named name,
+// partitioned by labelNames. name should follow Prometheus convention
+// (e.g. "wg_route_add_total").
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labelNames: labelNames, values: make(map[string]uint64)}
+	defaultRegistry.add(c)
+	return c
+}
+
+// Inc increments the counter identified by labelValues, which must be
+// given in the same order as labelNames.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter identified by labelValues by delta.
+func (c *CounterVec) Add(delta uint64, labelValues ...string) {
+	key := labelKey(labelValues)
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) render(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.values) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(b, "# TYPE %s counter\n", c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s%s %d\n", c.name, labelString(c.labelNames, key), c.values[key])
+	}
+}
+
+// GaugeVec is a value that can go up or down, partitioned by a fixed set
+// of label names.
+type GaugeVec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGaugeVec registers and returns a new gauge named name, partitioned
+// by labelNames.
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{name: name, help: help, labelNames: labelNames, values: make(map[string]float64)}
+	defaultRegistry.add(g)
+	return g
+}
+
+// Set records value for the gauge identified by labelValues, which must
+// be given in the same order as labelNames.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	g.mu.Lock()
+	g.values[key] = value
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) render(b *strings.Builder) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.values) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n", g.name, g.help)
+	fmt.Fprintf(b, "# TYPE %s gauge\n", g.name)
+	keys := make([]string, 0, len(g.values))
+	for key := range g.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s%s %v\n", g.name, labelString(g.labelNames, key), g.values[key])
+	}
+}
+
+// collector is implemented by CounterVec and GaugeVec so the registry
+// can render either without knowing which.
+type collector interface {
+	render(b *strings.Builder)
+}
+
+type registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func (r *registry) add(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Gather renders every registered counter and gauge in Prometheus text
+// exposition format.
+func (r *registry) Gather() string {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	var b strings.Builder
+	for _, c := range collectors {
+		c.render(&b)
+	}
+	return b.String()
+}
+
+var defaultRegistry = &registry{}
+
+// Gather renders every metric registered with this package in
+// Prometheus text exposition format.
+func Gather() string {
+	return defaultRegistry.Gather()
+}
+
+// labelKey joins label values into a single map key. Order matters and
+// must match the owning vec's labelNames.
+func labelKey(labelValues []string) string {
+	return strings.Join(labelValues, "\x00")
+}
+
+func labelString(labelNames []string, key string) string {
+	if len(labelNames) == 0 {
+		return ""
+	}
+	values := strings.Split(key, "\x00")
+	pairs := make([]string, 0, len(labelNames))
+	for i, name := range labelNames {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,119 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelKeyAndLabelString(t *testing.T) {
+	key := labelKey([]string{"ipv4", "ok"})
+	got := labelString([]string{"family", "result"}, key)
+	want := `{family="ipv4",result="ok"}`
+	if got != want {
+		t.Errorf("labelString = %q, want %q", got, want)
+	}
+}
+
+func TestLabelStringWithNoLabels(t *testing.T) {
+	if got := labelString(nil, ""); got != "" {
+		t.Errorf("labelString with no label names = %q, want empty string", got)
+	}
+}
+
+// These tests construct CounterVec/GaugeVec directly rather than through
+// New*Vec, which registers into the package-wide defaultRegistry shared
+// by every real metric - using that here would leak test values into
+// any other test's Gather() output.
+func TestCounterVecRender(t *testing.T) {
+	c := &CounterVec{
+		name:       "wg_test_total",
+		help:       "A test counter.",
+		labelNames: []string{"family"},
+		values:     make(map[string]uint64),
+	}
+	c.Inc("ipv4")
+	c.Add(2, "ipv4")
+	c.Inc("ipv6")
+
+	var b strings.Builder
+	c.render(&b)
+	out := b.String()
+
+	for _, want := range []string{
+		"# HELP wg_test_total A test counter.\n",
+		"# TYPE wg_test_total counter\n",
+		`wg_test_total{family="ipv4"} 3` + "\n",
+		`wg_test_total{family="ipv6"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("render output missing %q; got:\n%s", want, out)
+		}
+	}
+	// ipv4 was incremented first but must still sort before ipv6 in the
+	// rendered output either way, since render sorts by label key.
+	if strings.Index(out, `family="ipv4"`) > strings.Index(out, `family="ipv6"`) {
+		t.Errorf("render output not sorted by label key:\n%s", out)
+	}
+}
+
+func TestCounterVecRenderEmptyIsSuppressed(t *testing.T) {
+	c := &CounterVec{
+		name:       "wg_test_unused_total",
+		help:       "Never incremented.",
+		labelNames: []string{"family"},
+		values:     make(map[string]uint64),
+	}
+	var b strings.Builder
+	c.render(&b)
+	if b.Len() != 0 {
+		t.Errorf("render of an untouched CounterVec produced output: %q", b.String())
+	}
+}
+
+func TestGaugeVecRender(t *testing.T) {
+	g := &GaugeVec{
+		name:       "wg_test_bytes",
+		help:       "A test gauge.",
+		labelNames: []string{"family"},
+		values:     make(map[string]float64),
+	}
+	g.Set(1420, "ipv4")
+	g.Set(1280, "ipv6")
+
+	var b strings.Builder
+	g.render(&b)
+	out := b.String()
+
+	for _, want := range []string{
+		"# TYPE wg_test_bytes gauge\n",
+		`wg_test_bytes{family="ipv4"} 1420` + "\n",
+		`wg_test_bytes{family="ipv6"} 1280` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("render output missing %q; got:\n%s", want, out)
+		}
+	}
+}
+
+func TestRegistryGatherCombinesAllCollectors(t *testing.T) {
+	r := &registry{}
+	c := &CounterVec{name: "wg_test_a_total", help: "a", labelNames: nil, values: make(map[string]uint64)}
+	g := &GaugeVec{name: "wg_test_b", help: "b", labelNames: nil, values: make(map[string]float64)}
+	c.Inc()
+	g.Set(5)
+	r.add(c)
+	r.add(g)
+
+	out := r.Gather()
+	if !strings.Contains(out, "wg_test_a_total 1\n") {
+		t.Errorf("Gather output missing counter line; got:\n%s", out)
+	}
+	if !strings.Contains(out, "wg_test_b 5\n") {
+		t.Errorf("Gather output missing gauge line; got:\n%s", out)
+	}
+}
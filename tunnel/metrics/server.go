@@ -0,0 +1,66 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// policyKey and policyValue mirror the existing convention for
+// administrator-controlled behavior in this codebase: a DWORD under
+// HKLM\SOFTWARE\Policies\WireGuard. A value of 0, or the value being
+// absent altogether, leaves metrics off; this is opt-in, not
+// on-by-default telemetry. A nonzero value is the TCP port, on loopback
+// only, to serve /metrics on.
+const (
+	policyKey   = `SOFTWARE\Policies\WireGuard`
+	policyValue = `ExperimentalMetricsPort`
+)
+
+// Enabled reports the loopback TCP port the metrics server should listen
+// on, per the ExperimentalMetricsPort policy, and whether that policy is
+// set to a nonzero value at all.
+func Enabled() (port uint32, ok bool) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, policyKey, registry.QUERY_VALUE)
+	if err != nil {
+		return 0, false
+	}
+	defer k.Close()
+	v, _, err := k.GetIntegerValue(policyValue)
+	if err != nil || v == 0 {
+		return 0, false
+	}
+	return uint32(v), true
+}
+
+// StartServer starts a loopback-only HTTP server exposing Gather() in
+// Prometheus text exposition format at /metrics, if the
+// ExperimentalMetricsPort policy enables it. If metrics are disabled, it
+// returns a nil stop function and a nil error. The caller should arrange
+// to call stop when the tunnel shuts down.
+func StartServer() (stop func(), err error) {
+	port, ok := Enabled()
+	if !ok {
+		return nil, nil
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		io.WriteString(w, Gather())
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	return func() { srv.Close() }, nil
+}
@@ -0,0 +1,21 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// familyLabel renders family as the "ipv4"/"ipv6" label value used
+// throughout tunnel/metrics.
+func familyLabel(family winipcfg.AddressFamily) string {
+	if family == windows.AF_INET6 {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
@@ -0,0 +1,193 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+// wrapBindForPMTUD and wrapTUNForPMTUD, below, are the two hooks pmtud
+// needs into the data plane: the tunnel's device construction (where the
+// Bind and tun.Device passed to device.NewDevice are assembled) should
+// wrap both with these before handing them to the device, so that
+// discovery actually observes peer activity and enforces what it
+// discovers.
+
+import (
+	"net/netip"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/tunnel/pmtud"
+)
+
+// pmtudBind wraps the conn.Bind WireGuard sends and receives its UDP
+// traffic through, purely to feed MTUMonitor.NoteInboundTraffic every
+// datagram that arrives - the pmtud prober has no other way to learn
+// that a peer's path is still alive and acknowledge an outstanding
+// probe. Everything else is passed through to Bind unchanged.
+type pmtudBind struct {
+	conn.Bind
+	monitor *MTUMonitor
+}
+
+// wrapBindForPMTUD returns a Bind that behaves exactly like bind except
+// that every successfully received packet is also reported to monitor.
+func wrapBindForPMTUD(bind conn.Bind, monitor *MTUMonitor) conn.Bind {
+	return &pmtudBind{Bind: bind, monitor: monitor}
+}
+
+func (b *pmtudBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	fns, actualPort, err := b.Bind.Open(port)
+	if err != nil {
+		return nil, 0, err
+	}
+	wrapped := make([]conn.ReceiveFunc, len(fns))
+	for i, fn := range fns {
+		fn := fn
+		wrapped[i] = func(packets [][]byte, sizes []int, eps []conn.Endpoint) (n int, err error) {
+			n, err = fn(packets, sizes, eps)
+			for i := 0; i < n; i++ {
+				if endpoint, ok := endpointAddrPort(eps[i]); ok {
+					b.monitor.NoteInboundTraffic(endpoint)
+				}
+			}
+			return n, err
+		}
+	}
+	return wrapped, actualPort, nil
+}
+
+// endpointAddrPort recovers the netip.AddrPort a conn.Endpoint refers to,
+// for use as a pmtud.Key; conn.Endpoint only promises a string
+// representation, which for every Bind this tunnel runs on (UDP over IPv4
+// or IPv6) parses straight back into one.
+func endpointAddrPort(ep conn.Endpoint) (netip.AddrPort, bool) {
+	addrPort, err := netip.ParseAddrPort(ep.DstToString())
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	return addrPort, true
+}
+
+// pmtudTUN wraps the tun.Device the interface reads outbound packets
+// from, so that a packet too large for its destination peer's discovered
+// path MTU is turned back as a synthesized ICMP Fragmentation-Needed
+// (IPv4) or Packet Too Big (IPv6) reply instead of being handed to
+// WireGuard, which would otherwise have to fragment it or drop it
+// silently.
+type pmtudTUN struct {
+	tun.Device
+	monitor *MTUMonitor
+	router  *peerRouter
+}
+
+// wrapTUNForPMTUD returns a tun.Device that behaves like device except
+// that oversized outbound packets are intercepted per rejectOutbound below.
+func wrapTUNForPMTUD(device tun.Device, monitor *MTUMonitor, peers []conf.Peer) tun.Device {
+	return &pmtudTUN{Device: device, monitor: monitor, router: newPeerRouter(peers)}
+}
+
+func (t *pmtudTUN) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n, err := t.Device.Read(bufs, sizes, offset)
+	if err != nil {
+		return n, err
+	}
+	kept := 0
+	for i := 0; i < n; i++ {
+		packet := bufs[i][offset : offset+sizes[i]]
+		if t.rejectOutbound(packet) {
+			continue
+		}
+		if kept != i {
+			bufs[kept] = bufs[i]
+			sizes[kept] = sizes[i]
+		}
+		kept++
+	}
+	return kept, nil
+}
+
+// rejectOutbound reports whether packet, a full IP packet read from the
+// tun interface, exceeds its destination peer's discovered effective MTU.
+// If so, it writes the appropriate ICMP reply back to the interface
+// itself before returning true.
+func (t *pmtudTUN) rejectOutbound(packet []byte) bool {
+	dst, ok := destAddr(packet)
+	if !ok {
+		return false
+	}
+	pub, ok := t.router.lookup(dst)
+	if !ok {
+		return false
+	}
+	reject, nextHopMTU := t.monitor.ShouldRejectOutbound(pub, len(packet))
+	if !reject {
+		return false
+	}
+	var reply []byte
+	if dst.Is4() {
+		reply = pmtud.FragmentationNeeded(packet, uint16(nextHopMTU))
+	} else {
+		reply = pmtud.PacketTooBig(packet, nextHopMTU)
+	}
+	if reply != nil {
+		t.Device.Write([][]byte{reply}, 0)
+	}
+	return true
+}
+
+// destAddr extracts the destination address from an IPv4 or IPv6 packet.
+func destAddr(packet []byte) (netip.Addr, bool) {
+	if len(packet) < 1 {
+		return netip.Addr{}, false
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < 20 {
+			return netip.Addr{}, false
+		}
+		addr, _ := netip.AddrFromSlice(packet[16:20])
+		return addr, true
+	case 6:
+		if len(packet) < 40 {
+			return netip.Addr{}, false
+		}
+		addr, _ := netip.AddrFromSlice(packet[24:40])
+		return addr, true
+	default:
+		return netip.Addr{}, false
+	}
+}
+
+// peerRouter does a linear longest-prefix-match lookup of a destination
+// address against every peer's AllowedIPs, mirroring the simple
+// iterate-and-compare style already used for route tables elsewhere in
+// this package rather than building a trie for what is normally a
+// handful of peers.
+type peerRouter struct {
+	peers []conf.Peer
+}
+
+func newPeerRouter(peers []conf.Peer) *peerRouter {
+	return &peerRouter{peers: peers}
+}
+
+func (r *peerRouter) lookup(dst netip.Addr) (conf.Key, bool) {
+	var best netip.Prefix
+	var bestPub conf.Key
+	found := false
+	for _, peer := range r.peers {
+		for _, allowed := range peer.AllowedIPs {
+			if !allowed.Contains(dst) {
+				continue
+			}
+			if !found || allowed.Bits() > best.Bits() {
+				best = allowed
+				bestPub = peer.PublicKey
+				found = true
+			}
+		}
+	}
+	return bestPub, found
+}
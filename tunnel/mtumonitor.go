@@ -6,10 +6,56 @@
 package tunnel
 
 import (
+	"fmt"
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+
 	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/tunnel/health"
+	"golang.zx2c4.com/wireguard/windows/tunnel/metrics"
+	"golang.zx2c4.com/wireguard/windows/tunnel/pmtud"
 	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 )
 
+// probeTickInterval is how often MTUMonitor drives pmtud.Prober.Tick for
+// every peer path it currently knows the endpoint of. It needs to run
+// independent of the route/interface change callbacks below, since a
+// probe round also has to advance - or notice that it timed out - when
+// nothing about the physical route has changed at all.
+const probeTickInterval = 1 * time.Second
+
+// ipv4ProbeOverhead and ipv6ProbeOverhead are the IP+UDP header sizes (no
+// IP options, no extension headers) that a DF-set pmtud probe datagram
+// carries on top of its payload. pmtud.Prober's ifaceMTU input is its
+// binary search's upper bracket for that payload, not an on-wire packet
+// size, so these must be subtracted from the physical interface MTU
+// before it reaches the prober - otherwise candidates near the physical
+// MTU make pmtudsender.go's SendProbe fail synchronously with
+// WSAEMSGSIZE, which the prober can't tell apart from a genuine black
+// hole and clamps down to its floor in response.
+const (
+	ipv4ProbeOverhead = 20 + 8 // IPv4 header + UDP header
+	ipv6ProbeOverhead = 40 + 8 // IPv6 header + UDP header
+)
+
+// probeMTU returns the largest pmtud probe payload that can still fit in
+// a DF-set UDP datagram on an interface whose link MTU is ifaceMTU, for
+// the given family, floored at minMTU so the prober's bracket is never
+// left inverted or below its own protocol floor.
+func probeMTU(family winipcfg.AddressFamily, ifaceMTU, minMTU uint32) uint32 {
+	overhead := uint32(ipv4ProbeOverhead)
+	if family == windows.AF_INET6 {
+		overhead = ipv6ProbeOverhead
+	}
+	if ifaceMTU <= overhead || ifaceMTU-overhead < minMTU {
+		return minMTU
+	}
+	return ifaceMTU - overhead
+}
+
 func iterateForeignDefaultRoutes(family winipcfg.AddressFamily, ourLUID winipcfg.LUID, callback func(r *winipcfg.MibIPforwardRow2) error) error {
 	r, err := winipcfg.GetIPForwardTable2(family)
 	if err != nil {
@@ -51,21 +97,164 @@ func findDefaultLUID(family winipcfg.AddressFamily, ourLUID winipcfg.LUID, lastL
 	if err != nil {
 		return err
 	}
+	noDefaultRoute := health.NoDefaultRouteV4
+	if family == windows.AF_INET6 {
+		noDefaultRoute = health.NoDefaultRouteV6
+	}
+	if luid == 0 {
+		health.Default.Set(noDefaultRoute, health.Warning, "no foreign default route found")
+	} else {
+		health.Default.Clear(noDefaultRoute)
+		metrics.DefaultRouteMetric.Set(float64(lowestMetric), familyLabel(family))
+	}
 	if luid == *lastLUID && index == *lastIndex {
 		return nil
 	}
+	metrics.DefaultRouteChangeTotal.Inc(familyLabel(family))
 	*lastLUID = luid
 	*lastIndex = index
 	return nil
 }
 
-func monitorMTU(family winipcfg.AddressFamily, ourLUID winipcfg.LUID) ([]winipcfg.ChangeCallback, error) {
+// MTUMonitor keeps the tunnel interface MTU in step with the physical
+// default route, and in addition runs per-peer Path MTU Discovery
+// (tunnel/pmtud) against each configured peer endpoint so that the
+// interface-wide value - necessarily a conservative approximation - can
+// be refined per destination.
+type MTUMonitor struct {
+	callbacks []winipcfg.ChangeCallback
+	prober    *pmtud.Prober
+	peers     []conf.Key
+	lastIface uint32
+
+	ticker     *time.Ticker
+	tickerQuit chan struct{}
+
+	mu         sync.Mutex
+	endpoints  map[conf.Key]netip.AddrPort // last endpoint seen active for a peer
+	byEndpoint map[netip.AddrPort]conf.Key // reverse of endpoints, for attributing inbound traffic
+}
+
+// PeerActivity reports that a keepalive or data packet was received from
+// pub at endpoint, which pmtud treats as an acknowledgement of any probe
+// currently outstanding on that path. It also becomes the endpoint probed
+// by the next tick() round and by NoteInboundTraffic's reverse lookup,
+// until a later call reports a different one.
+func (m *MTUMonitor) PeerActivity(pub conf.Key, endpoint netip.AddrPort) {
+	m.mu.Lock()
+	if old, ok := m.endpoints[pub]; ok && old != endpoint {
+		delete(m.byEndpoint, old)
+	}
+	m.endpoints[pub] = endpoint
+	m.byEndpoint[endpoint] = pub
+	m.mu.Unlock()
+	m.prober.OnPeerActivity(pmtud.Key{PublicKey: pub, Endpoint: endpoint})
+}
+
+// NoteInboundTraffic reports that a packet arrived from endpoint on
+// WireGuard's UDP socket, without the caller needing to know which peer
+// that corresponds to. It is meant to be called from the data-plane
+// receive path (the conn.Bind WireGuard reads its sockets through) for
+// every inbound datagram; traffic from an endpoint that isn't currently
+// any peer's last-known endpoint is silently ignored.
+func (m *MTUMonitor) NoteInboundTraffic(endpoint netip.AddrPort) {
+	m.mu.Lock()
+	pub, ok := m.byEndpoint[endpoint]
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	m.PeerActivity(pub, endpoint)
+}
+
+// PeerMTU returns the discovered effective MTU for the path to pub at
+// endpoint, for display in the UI and over UAPI.
+func (m *MTUMonitor) PeerMTU(pub conf.Key, endpoint netip.AddrPort) (mtu uint32, discovered bool) {
+	m.mu.Lock()
+	ifaceMTU := m.lastIface
+	m.mu.Unlock()
+	return m.prober.EffectiveMTU(pmtud.Key{PublicKey: pub, Endpoint: endpoint}, ifaceMTU)
+}
+
+// ShouldRejectOutbound reports whether an outbound packetLen-byte packet
+// addressed to pub exceeds that peer's discovered effective MTU and
+// should be dropped with a synthesized ICMP Fragmentation-Needed/Packet
+// Too Big reply instead of being handed to WireGuard. It is meant to be
+// called from the tun device's read loop, alongside pmtud.FragmentationNeeded
+// / pmtud.PacketTooBig to build the reply. A peer with no currently known
+// endpoint is never rejected, since there is no path to have discovered
+// an MTU for yet.
+func (m *MTUMonitor) ShouldRejectOutbound(pub conf.Key, packetLen int) (reject bool, nextHopMTU uint32) {
+	m.mu.Lock()
+	endpoint, ok := m.endpoints[pub]
+	ifaceMTU := m.lastIface
+	m.mu.Unlock()
+	if !ok || ifaceMTU == 0 {
+		return false, 0
+	}
+	return m.prober.ShouldReject(pmtud.Key{PublicKey: pub, Endpoint: endpoint}, ifaceMTU, packetLen)
+}
+
+// tick drives pmtud's binary search forward for every peer path whose
+// endpoint is currently known, i.e. every peer PeerActivity has observed
+// at least one packet from. It is run every probeTickInterval by the
+// ticker goroutine started in monitorMTU.
+func (m *MTUMonitor) tick() {
+	m.mu.Lock()
+	ifaceMTU := m.lastIface
+	endpoints := make(map[conf.Key]netip.AddrPort, len(m.endpoints))
+	for pub, endpoint := range m.endpoints {
+		endpoints[pub] = endpoint
+	}
+	m.mu.Unlock()
+	if ifaceMTU == 0 {
+		return
+	}
+	for pub, endpoint := range endpoints {
+		err := m.prober.Tick(pmtud.Key{PublicKey: pub, Endpoint: endpoint}, ifaceMTU)
+		if err != nil {
+			log.Printf("[pmtud] probe to %v failed: %v", endpoint, err)
+		}
+	}
+}
+
+// Close unregisters the route and interface change callbacks and stops
+// the probe ticker.
+func (m *MTUMonitor) Close() {
+	close(m.tickerQuit)
+	m.ticker.Stop()
+	for _, cb := range m.callbacks {
+		cb.Unregister()
+	}
+}
+
+func monitorMTU(conf *conf.Config, family winipcfg.AddressFamily, ourLUID winipcfg.LUID) (*MTUMonitor, error) {
 	var minMTU uint32
 	if family == windows.AF_INET {
 		minMTU = 576
 	} else if family == windows.AF_INET6 {
 		minMTU = 1280
 	}
+	m := &MTUMonitor{
+		prober:     pmtud.New(newUDPProbeSender(family), minMTU),
+		tickerQuit: make(chan struct{}),
+		endpoints:  make(map[conf.Key]netip.AddrPort),
+		byEndpoint: make(map[netip.AddrPort]conf.Key),
+	}
+	for _, peer := range conf.Peers {
+		m.peers = append(m.peers, peer.PublicKey)
+		// Seed endpoints/byEndpoint from the peer's statically configured
+		// Endpoint so that tick() and ShouldRejectOutbound have a path to
+		// probe from the moment the tunnel comes up, rather than waiting
+		// on PeerActivity to be reported for the first time - which for a
+		// peer that never initiates (we are always the initiator) may
+		// never happen until after the handshake, and for the very first
+		// round of probing would otherwise never happen at all.
+		if endpoint, err := netip.ParseAddrPort(peer.Endpoint.String()); err == nil {
+			m.endpoints[peer.PublicKey] = endpoint
+			m.byEndpoint[endpoint] = peer.PublicKey
+		}
+	}
 	lastLUID := winipcfg.LUID(0)
 	lastIndex := ^uint32(0)
 	lastMTU := uint32(0)
@@ -89,15 +278,33 @@ func monitorMTU(family winipcfg.AddressFamily, ourLUID winipcfg.LUID) ([]winipcf
 			if err != nil {
 				return err
 			}
+			mtuClampedBelowFloor := health.MTUClampedBelowFloorV4
+			if family == windows.AF_INET6 {
+				mtuClampedBelowFloor = health.MTUClampedBelowFloorV6
+			}
 			iface.NLMTU = mtu - 80
 			if iface.NLMTU < minMTU {
 				iface.NLMTU = minMTU
+				health.Default.Set(mtuClampedBelowFloor, health.Warning, fmt.Sprintf("physical interface MTU %d too small; clamped to %d", mtu, minMTU))
+			} else {
+				health.Default.Clear(mtuClampedBelowFloor)
 			}
 			err = iface.Set()
 			if err != nil {
 				return err
 			}
 			lastMTU = mtu
+			m.mu.Lock()
+			m.lastIface = probeMTU(family, mtu, minMTU)
+			m.mu.Unlock()
+			metrics.TunnelMTUBytes.Set(float64(iface.NLMTU), familyLabel(family))
+			m.reprobeAll()
+		}
+		ourIface, err := ourLUID.Interface()
+		if err == nil && ourIface.OperStatus != winipcfg.IfOperStatusUp {
+			health.Default.Set(health.InterfaceDown, health.Error, "tunnel interface is not up")
+		} else {
+			health.Default.Clear(health.InterfaceDown)
 		}
 		return nil
 	}
@@ -122,5 +329,27 @@ func monitorMTU(family winipcfg.AddressFamily, ourLUID winipcfg.LUID) ([]winipcf
 		cbr.Unregister()
 		return nil, err
 	}
-	return []winipcfg.ChangeCallback{cbr, cbi}, nil
+	m.callbacks = []winipcfg.ChangeCallback{cbr, cbi}
+	m.ticker = time.NewTicker(probeTickInterval)
+	go func() {
+		for {
+			select {
+			case <-m.ticker.C:
+				m.tick()
+			case <-m.tickerQuit:
+				return
+			}
+		}
+	}()
+	return m, nil
+}
+
+// reprobeAll resets per-peer discovery so that a change to the physical
+// interface MTU (route/interface change callbacks already wired above)
+// is reflected in the next probing round rather than being stuck with a
+// bracket computed against a now-stale ceiling.
+func (m *MTUMonitor) reprobeAll() {
+	for _, pub := range m.peers {
+		m.prober.ForgetPeer(pub)
+	}
 }
@@ -0,0 +1,44 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestProbeMTUSubtractsIPv4Overhead(t *testing.T) {
+	got := probeMTU(windows.AF_INET, 1500, 576)
+	if want := uint32(1500 - ipv4ProbeOverhead); got != want {
+		t.Errorf("probeMTU(AF_INET, 1500, 576) = %d, want %d", got, want)
+	}
+}
+
+func TestProbeMTUSubtractsIPv6Overhead(t *testing.T) {
+	got := probeMTU(windows.AF_INET6, 1500, 1280)
+	if want := uint32(1500 - ipv6ProbeOverhead); got != want {
+		t.Errorf("probeMTU(AF_INET6, 1500, 1280) = %d, want %d", got, want)
+	}
+}
+
+func TestProbeMTUFloorsAtMinMTU(t *testing.T) {
+	// An interface MTU close to the IPv6 floor, once IP+UDP overhead is
+	// subtracted, must never dip below minMTU - the prober's own
+	// protocol floor - rather than handing it an inverted or
+	// too-small bracket.
+	got := probeMTU(windows.AF_INET6, 1300, 1280)
+	if got != 1280 {
+		t.Errorf("probeMTU(AF_INET6, 1300, 1280) = %d, want the floor 1280", got)
+	}
+}
+
+func TestProbeMTUNeverUnderflowsOnATinyInterfaceMTU(t *testing.T) {
+	got := probeMTU(windows.AF_INET, 20, 576)
+	if got != 576 {
+		t.Errorf("probeMTU(AF_INET, 20, 576) = %d, want the floor 576", got)
+	}
+}
@@ -0,0 +1,120 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package pmtud
+
+import "encoding/binary"
+
+// FragmentationNeeded builds an ICMPv4 "Fragmentation Needed" (Type 3,
+// Code 4) packet in response to ipPacket, a full IPv4 packet that exceeds
+// nextHopMTU and was sent with the don't-fragment bit set. nextHopMTU is reported
+// in the unused field of the ICMP header as required by RFC 1191. The
+// returned packet carries the offending IP header plus its first 8 bytes
+// of payload, as ICMP requires, and is addressed back to the original
+// source.
+func FragmentationNeeded(ipPacket []byte, nextHopMTU uint16) []byte {
+	if len(ipPacket) < 20 {
+		return nil
+	}
+	ihl := int(ipPacket[0]&0x0f) * 4
+	if ihl < 20 || len(ipPacket) < ihl {
+		return nil
+	}
+	quoteLen := ihl + 8
+	if len(ipPacket) < quoteLen {
+		quoteLen = len(ipPacket)
+	}
+
+	srcAddr := ipPacket[12:16]
+	dstAddr := ipPacket[16:20]
+
+	const icmpHeaderLen = 8
+	reply := make([]byte, 20+icmpHeaderLen+quoteLen)
+
+	// Outer IPv4 header: source and destination swapped from the
+	// original packet, as this is a reply travelling back to the sender.
+	reply[0] = 0x45 // version 4, IHL 5 (no options)
+	reply[1] = 0xc0 // DSCP: Internetwork Control
+	binary.BigEndian.PutUint16(reply[2:4], uint16(len(reply)))
+	reply[8] = 64 // TTL
+	reply[9] = 1  // protocol: ICMP
+	copy(reply[12:16], dstAddr)
+	copy(reply[16:20], srcAddr)
+	binary.BigEndian.PutUint16(reply[10:12], ipChecksum(reply[0:20]))
+
+	icmp := reply[20:]
+	icmp[0] = 3 // Destination Unreachable
+	icmp[1] = 4 // Fragmentation Needed and DF was set
+	// icmp[2:4] checksum, filled below
+	// icmp[4:6] unused (must be zero)
+	binary.BigEndian.PutUint16(icmp[6:8], nextHopMTU)
+	copy(icmp[icmpHeaderLen:], ipPacket[:quoteLen])
+	binary.BigEndian.PutUint16(icmp[2:4], ipChecksum(icmp))
+
+	return reply
+}
+
+// PacketTooBig builds an ICMPv6 "Packet Too Big" message in response to
+// ipPacket, a full IPv6 packet that exceeded mtu. As much of the
+// original packet as fits is included so the total message does not
+// itself exceed the minimum IPv6 MTU of 1280 bytes, per RFC 4443 section
+// 3.2.
+func PacketTooBig(ipPacket []byte, mtu uint32) []byte {
+	if len(ipPacket) < 40 {
+		return nil
+	}
+	srcAddr := ipPacket[8:24]
+	dstAddr := ipPacket[24:40]
+
+	const icmpHeaderLen = 8
+	const maxReply = 1280
+	quoteLen := len(ipPacket)
+	if 40+icmpHeaderLen+quoteLen > maxReply {
+		quoteLen = maxReply - 40 - icmpHeaderLen
+	}
+
+	icmp := make([]byte, icmpHeaderLen+quoteLen)
+	icmp[0] = 2 // Packet Too Big
+	icmp[1] = 0 // code 0
+	// icmp[2:4] checksum, filled below
+	binary.BigEndian.PutUint32(icmp[4:8], mtu)
+	copy(icmp[icmpHeaderLen:], ipPacket[:quoteLen])
+	binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(dstAddr, srcAddr, icmp))
+
+	reply := make([]byte, 40+len(icmp))
+	reply[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(reply[4:6], uint16(len(icmp)))
+	reply[6] = 58 // next header: ICMPv6
+	reply[7] = 64 // hop limit
+	copy(reply[8:24], dstAddr)
+	copy(reply[24:40], srcAddr)
+	copy(reply[40:], icmp)
+
+	return reply
+}
+
+func ipChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+func icmpv6Checksum(src, dst []byte, icmp []byte) uint16 {
+	pseudo := make([]byte, 40+len(icmp))
+	copy(pseudo[0:16], src)
+	copy(pseudo[16:32], dst)
+	binary.BigEndian.PutUint32(pseudo[32:36], uint32(len(icmp)))
+	pseudo[39] = 58 // next header: ICMPv6
+	copy(pseudo[40:], icmp)
+	return ipChecksum(pseudo)
+}
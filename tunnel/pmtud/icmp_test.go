@@ -0,0 +1,127 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package pmtud
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func ipv4Packet(payloadLen int) []byte {
+	p := make([]byte, 20+payloadLen)
+	p[0] = 0x45
+	binary.BigEndian.PutUint16(p[2:4], uint16(len(p)))
+	copy(p[12:16], []byte{10, 0, 0, 1})
+	copy(p[16:20], []byte{10, 0, 0, 2})
+	return p
+}
+
+func ipv6Packet(payloadLen int) []byte {
+	p := make([]byte, 40+payloadLen)
+	p[0] = 0x60
+	binary.BigEndian.PutUint16(p[4:6], uint16(payloadLen))
+	for i := range p[8:24] {
+		p[8+i] = byte(i + 1)
+	}
+	for i := range p[24:40] {
+		p[24+i] = byte(i + 100)
+	}
+	return p
+}
+
+func TestFragmentationNeeded(t *testing.T) {
+	original := ipv4Packet(40)
+	reply := FragmentationNeeded(original, 1400)
+	if reply == nil {
+		t.Fatal("FragmentationNeeded returned nil for a well-formed packet")
+	}
+	if reply[0] != 0x45 {
+		t.Errorf("reply version/IHL byte = %#x, want 0x45", reply[0])
+	}
+	// Source and destination are swapped relative to the original.
+	if string(reply[12:16]) != string(original[16:20]) || string(reply[16:20]) != string(original[12:16]) {
+		t.Errorf("reply did not swap source/destination addresses")
+	}
+	if reply[9] != 1 {
+		t.Errorf("reply protocol = %d, want 1 (ICMP)", reply[9])
+	}
+	icmp := reply[20:]
+	if icmp[0] != 3 || icmp[1] != 4 {
+		t.Errorf("reply ICMP type/code = %d/%d, want 3/4", icmp[0], icmp[1])
+	}
+	if got := binary.BigEndian.Uint16(icmp[6:8]); got != 1400 {
+		t.Errorf("reply next-hop MTU = %d, want 1400", got)
+	}
+	if ipChecksum(reply[0:20]) != 0 {
+		t.Errorf("outer IP header checksum does not validate")
+	}
+	if ipChecksum(icmp) != 0 {
+		t.Errorf("ICMP checksum does not validate")
+	}
+	// The quoted portion is the original header plus its first 8 bytes
+	// of payload.
+	quoted := icmp[8:]
+	if len(quoted) != 20+8 {
+		t.Fatalf("quoted original packet is %d bytes, want %d", len(quoted), 20+8)
+	}
+	if string(quoted) != string(original[:20+8]) {
+		t.Errorf("quoted original packet does not match")
+	}
+}
+
+func TestFragmentationNeededRejectsShortOrMalformedPackets(t *testing.T) {
+	if got := FragmentationNeeded(nil, 1400); got != nil {
+		t.Errorf("FragmentationNeeded(nil) = %v, want nil", got)
+	}
+	if got := FragmentationNeeded(make([]byte, 10), 1400); got != nil {
+		t.Errorf("FragmentationNeeded with a too-short packet = %v, want nil", got)
+	}
+}
+
+func TestPacketTooBig(t *testing.T) {
+	original := ipv6Packet(100)
+	reply := PacketTooBig(original, 1280)
+	if reply == nil {
+		t.Fatal("PacketTooBig returned nil for a well-formed packet")
+	}
+	if reply[0]>>4 != 6 {
+		t.Errorf("reply IP version = %d, want 6", reply[0]>>4)
+	}
+	// Source and destination are swapped relative to the original.
+	if string(reply[8:24]) != string(original[24:40]) || string(reply[24:40]) != string(original[8:24]) {
+		t.Errorf("reply did not swap source/destination addresses")
+	}
+	if reply[6] != 58 {
+		t.Errorf("reply next header = %d, want 58 (ICMPv6)", reply[6])
+	}
+	icmp := reply[40:]
+	if icmp[0] != 2 || icmp[1] != 0 {
+		t.Errorf("reply ICMPv6 type/code = %d/%d, want 2/0", icmp[0], icmp[1])
+	}
+	if got := binary.BigEndian.Uint32(icmp[4:8]); got != 1280 {
+		t.Errorf("reply MTU = %d, want 1280", got)
+	}
+	if len(reply) > 1280 {
+		t.Errorf("reply is %d bytes, exceeds the minimum IPv6 MTU of 1280", len(reply))
+	}
+}
+
+func TestPacketTooBigTruncatesToFitMinimumIPv6MTU(t *testing.T) {
+	original := ipv6Packet(2000)
+	reply := PacketTooBig(original, 1280)
+	if reply == nil {
+		t.Fatal("PacketTooBig returned nil")
+	}
+	if len(reply) > 1280 {
+		t.Errorf("reply is %d bytes, want at most 1280", len(reply))
+	}
+}
+
+func TestPacketTooBigRejectsShortPackets(t *testing.T) {
+	if got := PacketTooBig(make([]byte, 10), 1280); got != nil {
+		t.Errorf("PacketTooBig with a too-short packet = %v, want nil", got)
+	}
+}
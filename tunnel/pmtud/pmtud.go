@@ -0,0 +1,233 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package pmtud implements per-peer Path MTU Discovery along the lines of
+// RFC 8899 (packetization-layer path MTU discovery): probe payloads whose
+// sizes binary-search between the protocol floor and the interface MTU
+// are sent with the IP "don't fragment" bit set, and a
+// size is considered to have made it across the path once the peer is
+// observed to be alive again (handshake or data receive) within a short
+// timeout of the probe. Sizes that black-hole repeatedly fall back to the
+// protocol floor rather than being retried forever.
+package pmtud
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+const (
+	// probeTimeout is how long we wait for activity from a peer after a
+	// probe before considering the candidate size lost.
+	probeTimeout = 2 * time.Second
+
+	// reprobeInterval is how long a cached effective MTU is trusted
+	// before it is re-validated with a fresh probe.
+	reprobeInterval = 10 * time.Minute
+
+	// blackHoleThreshold is the number of consecutive losses at a given
+	// candidate size before we give up on it and fall back to minMTU.
+	blackHoleThreshold = 3
+
+	// overhead is the per-packet WireGuard encapsulation overhead
+	// (UDP/IP header is accounted for separately by the caller; this is
+	// just the WireGuard header itself) subtracted from a validated wire
+	// size to obtain the usable tunnel MTU.
+	overhead = 32
+)
+
+// Key identifies the path being probed: a peer's public key together with
+// the endpoint currently in use, since the effective MTU is only valid
+// for the path it was measured on.
+type Key struct {
+	PublicKey conf.Key
+	Endpoint  netip.AddrPort
+}
+
+// Sender transmits a DF-set UDP probe payload of len(payload) bytes to
+// endpoint. It is implemented by the caller, which decides which socket
+// the probe leaves from.
+type Sender interface {
+	SendProbe(endpoint netip.AddrPort, payload []byte) error
+}
+
+type pathState struct {
+	mu sync.Mutex
+
+	low, high uint32 // current binary-search bracket, inclusive
+	candidate uint32 // size currently outstanding, 0 if none in flight
+	losses    int    // consecutive losses at candidate
+	probedAt  time.Time
+
+	mtu        uint32 // last validated size minus overhead
+	validated  bool
+	validSince time.Time
+}
+
+// Prober tracks PMTU state for a set of peer paths and drives the
+// probing state machine forward as activity and timer ticks arrive.
+type Prober struct {
+	sender Sender
+	minMTU uint32
+
+	mu    sync.Mutex
+	paths map[Key]*pathState
+}
+
+// New returns a Prober that never probes below minMTU (576 for IPv4, 1280
+// for IPv6, matching the floors already enforced by monitorMTU) and sends
+// probe payloads via sender.
+func New(sender Sender, minMTU uint32) *Prober {
+	return &Prober{
+		sender: sender,
+		minMTU: minMTU,
+		paths:  make(map[Key]*pathState),
+	}
+}
+
+func (p *Prober) path(key Key, ifaceMTU uint32) *pathState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.paths[key]
+	if !ok {
+		s = &pathState{low: p.minMTU, high: ifaceMTU}
+		p.paths[key] = s
+	}
+	return s
+}
+
+// EffectiveMTU returns the most recently validated MTU for key, or
+// ifaceMTU-overhead with ok=false if discovery has not yet converged on
+// anything better than the interface default.
+func (p *Prober) EffectiveMTU(key Key, ifaceMTU uint32) (mtu uint32, ok bool) {
+	p.mu.Lock()
+	s, exists := p.paths[key]
+	p.mu.Unlock()
+	if !exists || !s.validated {
+		return ifaceMTU - overhead, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mtu, true
+}
+
+// Tick drives the binary search forward for key if there is no probe
+// currently outstanding, or declares the outstanding candidate lost if
+// probeTimeout has elapsed without peer activity. It is intended to be
+// called periodically (mtumonitor.go calls it from a ticker, independent
+// of its route/interface change callbacks).
+func (p *Prober) Tick(key Key, ifaceMTU uint32) error {
+	s := p.path(key, ifaceMTU)
+	s.mu.Lock()
+	if s.high < p.minMTU {
+		s.high = ifaceMTU
+	}
+	if s.candidate != 0 {
+		if time.Since(s.probedAt) < probeTimeout {
+			s.mu.Unlock()
+			return nil
+		}
+		// Lost: the candidate size did not make it across.
+		s.high = s.candidate - 1
+		s.losses++
+		if s.losses >= blackHoleThreshold {
+			// Black hole detected at every size we've tried this
+			// round; fall back to the safe floor and start over.
+			s.low, s.high = p.minMTU, ifaceMTU
+			s.losses = 0
+			s.mtu = p.minMTU - overhead
+			s.validated = true
+			s.validSince = time.Now()
+		}
+		s.candidate = 0
+	} else if s.validated && time.Since(s.validSince) < reprobeInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.low > s.high {
+		s.low, s.high = p.minMTU, ifaceMTU
+	}
+	candidate := s.low + (s.high-s.low+1)/2
+	if candidate < p.minMTU {
+		candidate = p.minMTU
+	}
+	s.candidate = candidate
+	s.probedAt = time.Now()
+	s.mu.Unlock()
+
+	payload := make([]byte, candidate)
+	return p.sender.SendProbe(key.Endpoint, payload)
+}
+
+// OnPeerActivity is called whenever a keepalive or data packet is
+// received from the peer at key. If a probe is currently outstanding, its
+// candidate size is taken to have been acknowledged by the path and the
+// search bracket narrows towards it.
+func (p *Prober) OnPeerActivity(key Key) {
+	p.mu.Lock()
+	s, ok := p.paths[key]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.candidate == 0 || time.Since(s.probedAt) >= probeTimeout {
+		return
+	}
+	s.low = s.candidate
+	s.losses = 0
+	if s.low >= s.high {
+		s.mtu = s.low - overhead
+		s.validated = true
+		s.validSince = time.Now()
+	}
+	s.candidate = 0
+}
+
+// Forget discards cached state for key, e.g. when a peer's endpoint
+// changes and the old path is no longer meaningful.
+func (p *Prober) Forget(key Key) {
+	p.mu.Lock()
+	delete(p.paths, key)
+	p.mu.Unlock()
+}
+
+// ForgetPeer discards cached state for every path currently tracked
+// against pub, regardless of which endpoint it was measured on. Used
+// when something that invalidates all of a peer's paths at once occurs,
+// such as the physical interface MTU changing.
+func (p *Prober) ForgetPeer(pub conf.Key) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key := range p.paths {
+		if key.PublicKey == pub {
+			delete(p.paths, key)
+		}
+	}
+}
+
+// ShouldReject reports whether an outbound packet of size packetLen
+// addressed to the peer at key exceeds that peer's discovered effective
+// MTU and should therefore be dropped with an ICMP
+// Fragmentation-Needed/Packet-Too-Big reply rather than handed to
+// WireGuard for encapsulation. It is meant to be called from the tun
+// device's read loop for every packet destined to one of a peer's
+// AllowedIPs, alongside the existing interface-wide MTU enforced by
+// monitorMTU. nextHopMTU is the value to report back to the sender when
+// rejecting.
+func (p *Prober) ShouldReject(key Key, ifaceMTU uint32, packetLen int) (reject bool, nextHopMTU uint32) {
+	mtu, ok := p.EffectiveMTU(key, ifaceMTU)
+	if !ok {
+		return false, 0
+	}
+	if uint32(packetLen) <= mtu {
+		return false, 0
+	}
+	return true, mtu
+}
@@ -0,0 +1,159 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package pmtud
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+// fakeSender records the size of the last probe sent, without putting
+// anything on a wire; tests acknowledge it by calling OnPeerActivity
+// directly, exactly as mtumonitor.go's NoteInboundTraffic would once
+// real peer traffic arrived.
+type fakeSender struct {
+	lastSize int
+}
+
+func (s *fakeSender) SendProbe(endpoint netip.AddrPort, payload []byte) error {
+	s.lastSize = len(payload)
+	return nil
+}
+
+func testKey() Key {
+	return Key{PublicKey: conf.Key{1}, Endpoint: netip.MustParseAddrPort("192.0.2.1:51820")}
+}
+
+func TestProberConvergesOnPathMTU(t *testing.T) {
+	sender := &fakeSender{}
+	p := New(sender, 576)
+	key := testKey()
+	const ifaceMTU = 1420
+
+	// Every candidate this loop probes is acknowledged, so the binary
+	// search should converge upward until low meets high.
+	for i := 0; i < 32; i++ {
+		if err := p.Tick(key, ifaceMTU); err != nil {
+			t.Fatalf("Tick: %v", err)
+		}
+		if mtu, ok := p.EffectiveMTU(key, ifaceMTU); ok {
+			if want := ifaceMTU - overhead; mtu != want {
+				t.Errorf("EffectiveMTU = %d, want %d", mtu, want)
+			}
+			return
+		}
+		p.OnPeerActivity(key)
+	}
+	t.Fatal("prober did not converge within 32 rounds")
+}
+
+func TestProberFallsBackBelowBlackHole(t *testing.T) {
+	sender := &fakeSender{}
+	p := New(sender, 576)
+	key := testKey()
+	const ifaceMTU = 1420
+
+	// Never acknowledge a probe: every candidate size is lost, and after
+	// blackHoleThreshold consecutive losses the prober should give up
+	// and fall back to the configured floor.
+	for i := 0; i < blackHoleThreshold; i++ {
+		if err := p.Tick(key, ifaceMTU); err != nil {
+			t.Fatalf("Tick: %v", err)
+		}
+		// Force the outstanding candidate to be treated as timed out on
+		// the next Tick.
+		forceProbeTimeout(p, key)
+	}
+	if err := p.Tick(key, ifaceMTU); err != nil {
+		t.Fatalf("Tick: %v", err)
+	}
+	mtu, ok := p.EffectiveMTU(key, ifaceMTU)
+	if !ok {
+		t.Fatal("EffectiveMTU not validated after black hole fallback")
+	}
+	if want := 576 - overhead; mtu != want {
+		t.Errorf("EffectiveMTU = %d, want floor %d", mtu, want)
+	}
+}
+
+// forceProbeTimeout backdates the outstanding probe's timestamp so the
+// next Tick treats it as lost, without sleeping the test for
+// probeTimeout.
+func forceProbeTimeout(p *Prober, key Key) {
+	p.mu.Lock()
+	s := p.paths[key]
+	p.mu.Unlock()
+	s.mu.Lock()
+	s.probedAt = s.probedAt.Add(-probeTimeout - time.Millisecond)
+	s.mu.Unlock()
+}
+
+func TestShouldRejectOutbound(t *testing.T) {
+	sender := &fakeSender{}
+	p := New(sender, 576)
+	key := testKey()
+	const ifaceMTU = 1420
+
+	// Before anything has validated, ShouldReject defers to the
+	// interface default and never rejects.
+	if reject, _ := p.ShouldReject(key, ifaceMTU, 9000); reject {
+		t.Fatal("ShouldReject rejected before any discovery happened")
+	}
+
+	for i := 0; i < 32; i++ {
+		p.Tick(key, ifaceMTU)
+		if _, ok := p.EffectiveMTU(key, ifaceMTU); ok {
+			break
+		}
+		p.OnPeerActivity(key)
+	}
+	mtu, ok := p.EffectiveMTU(key, ifaceMTU)
+	if !ok {
+		t.Fatal("prober did not converge")
+	}
+
+	if reject, _ := p.ShouldReject(key, ifaceMTU, int(mtu)); reject {
+		t.Errorf("ShouldReject rejected a packet exactly at the discovered MTU")
+	}
+	reject, nextHopMTU := p.ShouldReject(key, ifaceMTU, int(mtu)+1)
+	if !reject {
+		t.Errorf("ShouldReject did not reject a packet one byte over the discovered MTU")
+	}
+	if nextHopMTU != mtu {
+		t.Errorf("nextHopMTU = %d, want %d", nextHopMTU, mtu)
+	}
+}
+
+func TestForgetPeerDropsEveryPathForThatPeer(t *testing.T) {
+	sender := &fakeSender{}
+	p := New(sender, 576)
+	pub := conf.Key{1}
+	key1 := Key{PublicKey: pub, Endpoint: netip.MustParseAddrPort("192.0.2.1:51820")}
+	key2 := Key{PublicKey: pub, Endpoint: netip.MustParseAddrPort("192.0.2.2:51820")}
+	other := Key{PublicKey: conf.Key{2}, Endpoint: netip.MustParseAddrPort("192.0.2.3:51820")}
+
+	p.Tick(key1, 1420)
+	p.Tick(key2, 1420)
+	p.Tick(other, 1420)
+
+	p.ForgetPeer(pub)
+
+	p.mu.Lock()
+	_, key1Present := p.paths[key1]
+	_, key2Present := p.paths[key2]
+	_, otherPresent := p.paths[other]
+	p.mu.Unlock()
+
+	if key1Present || key2Present {
+		t.Error("ForgetPeer left a path behind for the forgotten peer")
+	}
+	if !otherPresent {
+		t.Error("ForgetPeer dropped a path belonging to a different peer")
+	}
+}
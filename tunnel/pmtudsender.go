@@ -0,0 +1,87 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/pmtud"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// icmpErrorWindow is how long SendProbe keeps its socket open after
+// writing a probe, waiting to see whether the OS reports back an
+// ICMPv4/v6 "too big" error triggered by that exact probe - the one
+// concrete signal RFC 8899 probing is meant to use - before giving up on
+// it and falling back to the caller's probeTimeout-based validation
+// against ambient peer traffic.
+const icmpErrorWindow = 100 * time.Millisecond
+
+// udpProbeSender sends pmtud probe payloads over an unconnected UDP
+// socket with the IP "don't fragment" bit forced on, independent of
+// whatever the OS would otherwise choose for a packet this size.
+type udpProbeSender struct {
+	family winipcfg.AddressFamily
+}
+
+func newUDPProbeSender(family winipcfg.AddressFamily) *udpProbeSender {
+	return &udpProbeSender{family: family}
+}
+
+func (s *udpProbeSender) SendProbe(endpoint netip.AddrPort, payload []byte) error {
+	network := "udp4"
+	if endpoint.Addr().Is6() {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockoptErr error
+	err = raw.Control(func(fd uintptr) {
+		if network == "udp4" {
+			sockoptErr = windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IP, windows.IP_DONTFRAGMENT, 1)
+		} else {
+			sockoptErr = windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IPV6, windows.IPV6_DONTFRAG, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if sockoptErr != nil {
+		return sockoptErr
+	}
+	_, err = conn.WriteToUDPAddrPort(payload, endpoint)
+	if err != nil {
+		return err
+	}
+
+	// A too-big probe can come back as an immediate, synchronous error
+	// on this same socket rather than only as silence; wait a short
+	// while to catch it instead of closing blind. Anything we read back
+	// here is never a real reply - the peer doesn't know to answer a
+	// pmtud probe - so the only meaningful outcome of this read is an
+	// error, or the deadline expiring with no error to report.
+	conn.SetReadDeadline(time.Now().Add(icmpErrorWindow))
+	var discard [1]byte
+	_, _, err = conn.ReadFromUDPAddrPort(discard[:])
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		return err
+	}
+	return nil
+}
+
+var _ pmtud.Sender = (*udpProbeSender)(nil)
@@ -16,6 +16,8 @@ import (
 	"codeberg.org/eduVPN/proxyguard"
 	"golang.org/x/sys/windows"
 	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/tunnel/health"
+	"golang.zx2c4.com/wireguard/windows/tunnel/metrics"
 	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 )
 
@@ -33,13 +35,79 @@ func init() {
 	proxyguard.UpdateLogger(&ProxyLogger{})
 }
 
-type proxy struct {
+// PeerProxy is implemented by anything capable of relaying a peer's
+// WireGuard UDP traffic to a reachable listen address, regardless of the
+// relay protocol in use. monitorProxyRoutes only depends on this
+// interface, so it works the same way against any implementation.
+type PeerProxy interface {
+	// Tunnel runs the proxy until ctx is cancelled or it fails
+	// unrecoverably, relaying WireGuard's local Listen traffic to
+	// endpoint. ips are the endpoint's already-resolved addresses.
+	// ready is called once the proxy is accepting traffic on Listen.
+	Tunnel(ctx context.Context, endpoint string, ips []string, ready func()) error
+
+	// SignalRestart asks a running proxy to reconnect to its upstream,
+	// e.g. after the default route has changed underneath it.
+	SignalRestart() error
+
+	// Addresses are the resolved addresses monitorProxyRoutes must keep
+	// a route to for this proxy's upstream connection to stay up.
+	Addresses() []netip.Addr
+
+	// listenAddr is the local address WireGuard is told to use as this
+	// peer's Endpoint, used only for logging and health warnable names.
+	listenAddr() string
+}
+
+// proxyguardProxy is the original PeerProxy implementation, wrapping
+// codeberg.org/eduVPN/proxyguard for the proxyguard+https:// scheme.
+type proxyguardProxy struct {
 	proxyguard.Client
-	Addresses []netip.Addr
+	addresses []netip.Addr
+}
+
+func (p *proxyguardProxy) Addresses() []netip.Addr { return p.addresses }
+func (p *proxyguardProxy) listenAddr() string      { return p.Listen }
+
+func (p *proxyguardProxy) Tunnel(ctx context.Context, endpoint string, ips []string, ready func()) error {
+	p.Client.Ready = ready
+	return p.Client.Tunnel(ctx, endpoint, ips)
+}
+
+// newPeerProxy constructs the PeerProxy implementation appropriate for
+// proxyEndpoint's URL scheme:
+//
+//   - proxyguard+https:// (or proxyguard://, or a URL with no scheme
+//     such as //host:port): the original Proxyguard-based relay. A bare
+//     host:port is not accepted: url.Parse either fails on it or reads
+//     the host as a scheme.
+//   - socks5://[user:pass@]host:port: a SOCKS5 UDP ASSOCIATE relay
+//     (RFC 1928).
+//   - http(s)://host:port: an HTTP CONNECT-UDP relay (RFC 9298).
+//
+// When a peer configures both Endpoint and ProxyEndpoint, ProxyEndpoint
+// takes precedence: Endpoint is still used as the address WireGuard
+// itself connects to (loopback, normally), while ProxyEndpoint is where
+// that loopback traffic actually gets relayed to.
+func newPeerProxy(listen string, proxyEndpoint string, addresses []netip.Addr) (PeerProxy, error) {
+	u, err := url.Parse(proxyEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "", "proxyguard", "proxyguard+https":
+		return &proxyguardProxy{Client: proxyguard.Client{Listen: listen}, addresses: addresses}, nil
+	case "socks5":
+		return newSocks5Proxy(listen, u, addresses)
+	case "http", "https":
+		return newHTTPConnectProxy(listen, u, addresses)
+	default:
+		return nil, fmt.Errorf("unsupported ProxyEndpoint scheme %q", u.Scheme)
+	}
 }
 
-func spawnProxies(conf *conf.Config, ctx context.Context) ([]*proxy, error) {
-	proxies := make([]*proxy, 0)
+func spawnProxies(conf *conf.Config, ctx context.Context) ([]PeerProxy, error) {
+	proxies := make([]PeerProxy, 0)
 	for _, peer := range conf.Peers {
 		if len(peer.ProxyEndpoint) > 0 {
 			log.Println("Resolving peer proxy name")
@@ -61,29 +129,63 @@ func spawnProxies(conf *conf.Config, ctx context.Context) ([]*proxy, error) {
 			}
 
 			log.Println("Spawning peer proxy")
-			proxyReady := make(chan error)
-			p := &proxy{
-				Client: proxyguard.Client{
-					Listen: peer.Endpoint.String(),
-					Ready:  func() { proxyReady <- nil },
-				},
-				Addresses: addresses,
+			p, err := newPeerProxy(peer.Endpoint.String(), peer.ProxyEndpoint, addresses)
+			if err != nil {
+				return nil, err
 			}
 			proxies = append(proxies, p)
-			go func() { proxyReady <- p.Tunnel(ctx, peer.ProxyEndpoint, pips) }()
+
+			warnable := proxyNotReadyWarnable(p)
+			health.Default.Set(warnable, health.Warning, "waiting for proxy to become ready")
+			metrics.ProxyReady.Set(0, p.listenAddr())
+			proxyReady := make(chan error)
+			go func() {
+				proxyReady <- p.Tunnel(ctx, peer.ProxyEndpoint, pips, func() { proxyReady <- nil })
+			}()
 			err = <-proxyReady
 			if err != nil {
+				health.Default.Set(warnable, health.Error, err.Error())
 				return nil, err
 			}
+			health.Default.Clear(warnable)
+			metrics.ProxyReady.Set(1, p.listenAddr())
 		}
 	}
 	return proxies, nil
 }
 
-func monitorProxyRoutes(family winipcfg.AddressFamily, ourLUID winipcfg.LUID, proxies []*proxy) ([]winipcfg.ChangeCallback, error) {
-	destProxy := make(map[netip.Prefix][]*proxy)
+// dialResolved dials port on each of ips in turn, succeeding on the
+// first one that connects. socks5Proxy and httpConnectProxy use this
+// instead of net.Dial("tcp", hostport) so that every reconnect lands on
+// the same address monitorProxyRoutes installed a route for, rather than
+// re-resolving the proxy's hostname - and potentially a different
+// address - on every attempt.
+func dialResolved(ctx context.Context, ips []string, port string) (net.Conn, error) {
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no resolved addresses to dial")
+	}
+	var dialer net.Dialer
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// proxyNotReadyWarnable is the health.ProxyNotReady warnable name for a
+// specific proxy, since a tunnel may have more than one peer proxy.
+func proxyNotReadyWarnable(p PeerProxy) string {
+	return health.ProxyNotReady + ":" + p.listenAddr()
+}
+
+func monitorProxyRoutes(family winipcfg.AddressFamily, ourLUID winipcfg.LUID, proxies []PeerProxy) ([]winipcfg.ChangeCallback, error) {
+	destProxy := make(map[netip.Prefix][]PeerProxy)
 	for _, p := range proxies {
-		for _, addr := range p.Addresses {
+		for _, addr := range p.Addresses() {
 			if family == windows.AF_INET && !addr.Is4() {
 				continue
 			}
@@ -104,7 +206,8 @@ func monitorProxyRoutes(family winipcfg.AddressFamily, ourLUID winipcfg.LUID, pr
 
 	doIt := func(restartProxies bool) error {
 		newRoutes := make(map[luidRouteData]bool)
-		proxiesToRestart := make(map[*proxy]bool)
+		proxiesToRestart := make(map[PeerProxy]bool)
+		routeFailed := false
 		err := iterateForeignDefaultRoutes(family, ourLUID, func(r *winipcfg.MibIPforwardRow2) error {
 			for destination := range destProxy {
 				nextHop := r.NextHop.Addr()
@@ -115,13 +218,16 @@ func monitorProxyRoutes(family winipcfg.AddressFamily, ourLUID winipcfg.LUID, pr
 					}
 				}
 				if err == nil || err == windows.ERROR_OBJECT_ALREADY_EXISTS {
+					metrics.RouteAddTotal.Inc(familyLabel(family), "ok")
 					newRoutes[luidRouteData{
 						luid:        r.InterfaceLUID,
 						destination: destination,
 						nextHop:     nextHop,
 					}] = true
 				} else {
+					metrics.RouteAddTotal.Inc(familyLabel(family), "error")
 					log.Printf("[Proxyguard] Failed to add route %v via %v: %v", destination, nextHop, err)
+					routeFailed = true
 				}
 			}
 			return nil
@@ -132,29 +238,38 @@ func monitorProxyRoutes(family winipcfg.AddressFamily, ourLUID winipcfg.LUID, pr
 		for r := range ourRoutes {
 			if _, keepRoute := newRoutes[r]; !keepRoute {
 				err := r.luid.DeleteRoute(r.destination, r.nextHop)
+				if err == nil || err == windows.ERROR_NOT_FOUND {
+					metrics.RouteDeleteTotal.Inc(familyLabel(family), "ok")
+				} else {
+					metrics.RouteDeleteTotal.Inc(familyLabel(family), "error")
+				}
 				if err == nil {
 					for _, p := range destProxy[r.destination] {
 						proxiesToRestart[p] = true
 					}
 				} else if err != windows.ERROR_NOT_FOUND {
 					log.Printf("[Proxyguard] Failed to delete route %v via %v: %v", r.destination, r.nextHop, err)
+					routeFailed = true
 				}
 			}
 		}
+		if routeFailed {
+			health.Default.Set(health.RouteAddFailed, health.Error, "failed to add or remove a proxy route; see log")
+		} else {
+			health.Default.Clear(health.RouteAddFailed)
+		}
 		ourRoutes = newRoutes
-		// TODO: This is commented for the time being, as there are timing issues and does more harm.
-		// Proxyguard cant handle rapid restart signals caused by multiple default route changes
-		// rendering it into a zombie. On the other hand, should routing change drop its HTTP(S)
-		// upstream connection, the Proxyguard will restart by its own.
-		// if restartProxies {
-		// 	for p := range proxiesToRestart {
-		// 		log.Printf("[Proxyguard] Signaling proxy %v to restart after default route change", p.Listen)
-		// 		err = p.SignalRestart()
-		// 		if err != nil {
-		// 			log.Printf("[Proxyguard] Failed to signal proxy %v to restart: %v", p.Listen, err)
-		// 		}
-		// 	}
-		// }
+		// Proxyguard used to get stuck as a "zombie" when rapid
+		// successive default-route notifications each triggered their
+		// own SignalRestart. The route/interface callbacks below no
+		// longer call doIt(true) directly; a routeChangeCoalescer
+		// debounces the bursts first, and requestProxyRestart enforces a
+		// per-proxy cooldown on top of that, so this is safe to do.
+		if restartProxies {
+			for p := range proxiesToRestart {
+				requestProxyRestart(p, proxyRestartCooldown)
+			}
+		}
 		return nil
 	}
 	err := doIt(false)
@@ -170,25 +285,32 @@ func monitorProxyRoutes(family winipcfg.AddressFamily, ourLUID winipcfg.LUID, pr
 			}
 		}
 		ourRoutes = make(map[luidRouteData]bool)
+		forgetProxyRestarts(proxies)
+	}
+
+	coalescer := newRouteChangeCoalescer(func() { doIt(true) })
+	cleanItAndStopCoalescer := func() {
+		coalescer.Close()
+		cleanIt()
 	}
 
 	cbr, err := winipcfg.RegisterRouteChangeCallback(func(notificationType winipcfg.MibNotificationType, route *winipcfg.MibIPforwardRow2) {
 		if route != nil && route.DestinationPrefix.PrefixLength == 0 {
-			doIt(true)
+			coalescer.Notify()
 		}
-	}, cleanIt)
+	}, cleanItAndStopCoalescer)
 	if err != nil {
-		cleanIt()
+		cleanItAndStopCoalescer()
 		return nil, err
 	}
 	cbi, err := winipcfg.RegisterInterfaceChangeCallback(func(notificationType winipcfg.MibNotificationType, iface *winipcfg.MibIPInterfaceRow) {
 		if notificationType == winipcfg.MibParameterNotification {
-			doIt(true)
+			coalescer.Notify()
 		}
-	}, cleanIt)
+	}, cleanItAndStopCoalescer)
 	if err != nil {
 		cbr.Unregister()
-		cleanIt()
+		cleanItAndStopCoalescer()
 		return nil, err
 	}
 	return []winipcfg.ChangeCallback{cbr, cbi}, nil
@@ -0,0 +1,66 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestSocks5UDPHeaderRoundTripIPv4(t *testing.T) {
+	target := &net.UDPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 51820}
+	header := socks5UDPHeader(target)
+	payload := []byte("hello")
+	datagram := append(append([]byte(nil), header...), payload...)
+
+	got, err := stripSocks5UDPHeader(datagram)
+	if err != nil {
+		t.Fatalf("stripSocks5UDPHeader: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("stripSocks5UDPHeader = %q, want %q", got, payload)
+	}
+}
+
+func TestSocks5UDPHeaderRoundTripIPv6(t *testing.T) {
+	target := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51820}
+	header := socks5UDPHeader(target)
+	if header[3] != 0x04 {
+		t.Fatalf("ATYP = %#x, want 0x04 for an IPv6 target", header[3])
+	}
+	payload := []byte("hello")
+	datagram := append(append([]byte(nil), header...), payload...)
+
+	got, err := stripSocks5UDPHeader(datagram)
+	if err != nil {
+		t.Fatalf("stripSocks5UDPHeader: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("stripSocks5UDPHeader = %q, want %q", got, payload)
+	}
+}
+
+func TestStripSocks5UDPHeaderRejectsShortDatagrams(t *testing.T) {
+	cases := [][]byte{
+		nil,
+		{0x00, 0x00, 0x00, 0x01, 1, 2, 3}, // IPv4 ATYP, too short for addr+port
+		{0x00, 0x00, 0x00, 0x04},          // IPv6 ATYP, no address at all
+		{0x00, 0x00, 0x00, 0x03, 5, 'h'},  // domain ATYP, name truncated
+	}
+	for _, c := range cases {
+		if _, err := stripSocks5UDPHeader(c); err == nil {
+			t.Errorf("stripSocks5UDPHeader(%v) = nil error, want an error", c)
+		}
+	}
+}
+
+func TestStripSocks5UDPHeaderRejectsUnsupportedATYP(t *testing.T) {
+	datagram := []byte{0x00, 0x00, 0x00, 0x7f, 1, 2, 3, 4}
+	if _, err := stripSocks5UDPHeader(datagram); err == nil {
+		t.Error("stripSocks5UDPHeader with an unsupported ATYP = nil error, want an error")
+	}
+}
@@ -0,0 +1,60 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestDialResolvedFallsBackToALaterAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("SplitHostPort: %v", err)
+	}
+
+	// 127.0.0.2 has nothing listening on it, so the first address must
+	// fail and dialResolved must fall through to the second.
+	conn, err := dialResolved(context.Background(), []string{"127.0.0.2", "127.0.0.1"}, port)
+	if err != nil {
+		t.Fatalf("dialResolved: %v", err)
+	}
+	defer conn.Close()
+	if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Errorf("dialResolved connected to %q, want 127.0.0.1", host)
+	}
+}
+
+func TestDialResolvedReturnsLastErrorWhenAllFail(t *testing.T) {
+	// Find a free port by listening on it and closing the listener again,
+	// then dial it with nothing listening there any more.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	_, portStr, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	port, _ := strconv.Atoi(portStr)
+
+	_, err = dialResolved(context.Background(), []string{"127.0.0.1"}, strconv.Itoa(port))
+	if err == nil {
+		t.Fatal("dialResolved succeeded dialing a closed port, want an error")
+	}
+}
+
+func TestDialResolvedRejectsEmptyAddressList(t *testing.T) {
+	_, err := dialResolved(context.Background(), nil, "51820")
+	if err == nil {
+		t.Fatal("dialResolved with no addresses = nil error, want an error")
+	}
+}
@@ -0,0 +1,210 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/tunnel/metrics"
+)
+
+const (
+	// routeChangeLeadingDebounce is how long monitorProxyRoutes waits
+	// after the first default-route notification in a burst before
+	// acting on it, so a single flip (Wi-Fi -> Ethernet) that produces
+	// several notifications in quick succession is only handled once.
+	routeChangeLeadingDebounce = 500 * time.Millisecond
+
+	// routeChangeTrailingDebounce is the wait restarted by every
+	// additional notification that arrives before the pending action has
+	// fired, so a noisy burst keeps pushing the action out until it
+	// actually goes quiet for this long.
+	routeChangeTrailingDebounce = 2 * time.Second
+
+	// proxyRestartCooldown is the minimum time between two
+	// SignalRestart calls for the same proxy, regardless of how many
+	// default-route changes occur in between.
+	proxyRestartCooldown = 10 * time.Second
+)
+
+// proxyRestartState is the state of a single proxy's restart state
+// machine: Idle -> PendingRestart (a restart has been requested but not
+// yet attempted) -> Restarting (SignalRestart is in flight) ->
+// Cooldown (recently restarted; further requests are deferred) -> Idle.
+type proxyRestartState int
+
+const (
+	proxyIdle proxyRestartState = iota
+	proxyPendingRestart
+	proxyRestarting
+	proxyCooldown
+)
+
+// proxyRestarts tracks the restart state machine for every PeerProxy
+// currently known to monitorProxyRoutes. It is keyed by the PeerProxy
+// interface value itself rather than a concrete type, since a tunnel's
+// proxies may be a mix of proxyguardProxy, socks5Proxy and
+// httpConnectProxy.
+var proxyRestarts = struct {
+	mu      sync.Mutex
+	entries map[PeerProxy]*proxyRestartEntry
+}{entries: make(map[PeerProxy]*proxyRestartEntry)}
+
+type proxyRestartEntry struct {
+	state         proxyRestartState
+	cooldownUntil time.Time
+
+	// pendingTimer is the time.AfterFunc scheduled below to re-issue a
+	// restart that arrived during cooldown, if any. forgetProxyRestarts
+	// stops it so a proxy torn down before its cooldown expires doesn't
+	// come back to life and recreate an entry for itself afterwards.
+	pendingTimer *time.Timer
+}
+
+// requestProxyRestart asks p to restart, honoring proxyRestartCooldown.
+// If p is already restarting, the request is a no-op. If p is cooling
+// down from a previous restart, the request is remembered and re-issued
+// once the cooldown expires, rather than being dropped on the floor.
+func requestProxyRestart(p PeerProxy, cooldown time.Duration) {
+	proxyRestarts.mu.Lock()
+	e, ok := proxyRestarts.entries[p]
+	if !ok {
+		e = &proxyRestartEntry{}
+		proxyRestarts.entries[p] = e
+	}
+	switch e.state {
+	case proxyRestarting:
+		proxyRestarts.mu.Unlock()
+		return
+	case proxyPendingRestart:
+		if time.Now().Before(e.cooldownUntil) {
+			// Already remembered; its re-issue is scheduled for when the
+			// cooldown expires.
+			proxyRestarts.mu.Unlock()
+			return
+		}
+	case proxyCooldown:
+		if time.Now().Before(e.cooldownUntil) {
+			e.state = proxyPendingRestart
+			e.pendingTimer = time.AfterFunc(time.Until(e.cooldownUntil), func() { requestProxyRestart(p, cooldown) })
+			proxyRestarts.mu.Unlock()
+			return
+		}
+	}
+	e.state = proxyRestarting
+	proxyRestarts.mu.Unlock()
+
+	log.Printf("[Proxyguard] Signaling proxy %v to restart after default route change", p.listenAddr())
+	err := p.SignalRestart()
+	if err != nil {
+		log.Printf("[Proxyguard] Failed to signal proxy %v to restart: %v", p.listenAddr(), err)
+	}
+	metrics.ProxyRestartTotal.Inc(p.listenAddr())
+
+	proxyRestarts.mu.Lock()
+	pending := e.state == proxyPendingRestart
+	e.state = proxyCooldown
+	e.cooldownUntil = time.Now().Add(cooldown)
+	proxyRestarts.mu.Unlock()
+
+	if pending {
+		timer := time.AfterFunc(cooldown, func() { requestProxyRestart(p, cooldown) })
+		proxyRestarts.mu.Lock()
+		if current, ok := proxyRestarts.entries[p]; ok && current == e {
+			e.pendingTimer = timer
+		} else {
+			// forgetProxyRestarts already dropped this entry (or a
+			// newer one replaced it) between us unlocking above and
+			// here; don't let the timer outlive it.
+			timer.Stop()
+		}
+		proxyRestarts.mu.Unlock()
+	}
+}
+
+// forgetProxyRestarts discards the restart state machine entries for
+// proxies. monitorProxyRoutes calls this as it tears down, since a fresh
+// set of PeerProxy values is constructed by spawnProxies on every
+// reconnect; without this, proxyRestarts.entries would grow by one
+// abandoned entry per proxy per reconnect for the life of the process.
+func forgetProxyRestarts(proxies []PeerProxy) {
+	proxyRestarts.mu.Lock()
+	defer proxyRestarts.mu.Unlock()
+	for _, p := range proxies {
+		if e, ok := proxyRestarts.entries[p]; ok && e.pendingTimer != nil {
+			e.pendingTimer.Stop()
+		}
+		delete(proxyRestarts.entries, p)
+	}
+}
+
+// routeChangeCoalescer collapses bursts of default-route change
+// notifications into a single call to fire, per the leading/trailing
+// debounce constants above, instead of invoking fire once per
+// notification as monitorProxyRoutes' callbacks receive them.
+type routeChangeCoalescer struct {
+	notifications chan struct{}
+	quit          chan struct{}
+	done          chan struct{}
+}
+
+func newRouteChangeCoalescer(fire func()) *routeChangeCoalescer {
+	c := &routeChangeCoalescer{
+		notifications: make(chan struct{}, 1),
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go c.run(fire)
+	return c
+}
+
+func (c *routeChangeCoalescer) run(fire func()) {
+	defer close(c.done)
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	for {
+		select {
+		case <-c.notifications:
+			if timer == nil {
+				timer = time.NewTimer(routeChangeLeadingDebounce)
+			} else {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(routeChangeTrailingDebounce)
+			}
+			timerC = timer.C
+		case <-timerC:
+			timer = nil
+			timerC = nil
+			fire()
+		case <-c.quit:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// Notify queues a default-route change for the coalescer to act on. A
+// notification already pending in the buffer makes this a no-op, which
+// is what drops duplicate destination-prefix-0 notifications that arrive
+// faster than the coalescer can drain them.
+func (c *routeChangeCoalescer) Notify() {
+	select {
+	case c.notifications <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the coalescer's goroutine and waits for it to exit.
+func (c *routeChangeCoalescer) Close() {
+	close(c.quit)
+	<-c.done
+}
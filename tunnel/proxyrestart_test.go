@@ -0,0 +1,128 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"context"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeProxy is a minimal PeerProxy used only to drive requestProxyRestart
+// in tests, without any of the real network handling a socks5Proxy or
+// httpConnectProxy would do.
+type fakeProxy struct {
+	restarts atomic.Int32
+}
+
+func (p *fakeProxy) Tunnel(ctx context.Context, endpoint string, ips []string, ready func()) error {
+	return nil
+}
+func (p *fakeProxy) SignalRestart() error    { p.restarts.Add(1); return nil }
+func (p *fakeProxy) Addresses() []netip.Addr { return nil }
+func (p *fakeProxy) listenAddr() string      { return "127.0.0.1:0" }
+
+var _ PeerProxy = (*fakeProxy)(nil)
+
+func TestRequestProxyRestartCooldown(t *testing.T) {
+	p := &fakeProxy{}
+	defer forgetProxyRestarts([]PeerProxy{p})
+
+	const cooldown = 50 * time.Millisecond
+
+	requestProxyRestart(p, cooldown)
+	if got := p.restarts.Load(); got != 1 {
+		t.Fatalf("first request: SignalRestart called %d times, want 1", got)
+	}
+
+	// A second request arriving during the cooldown window must not
+	// call SignalRestart again.
+	requestProxyRestart(p, cooldown)
+	if got := p.restarts.Load(); got != 1 {
+		t.Fatalf("request during cooldown: SignalRestart called %d times, want still 1", got)
+	}
+
+	// The request that arrived during the cooldown was remembered, and
+	// is re-issued on its own once the cooldown has elapsed.
+	time.Sleep(2 * cooldown)
+	if got := p.restarts.Load(); got != 2 {
+		t.Fatalf("after cooldown expired: SignalRestart called %d times, want 2", got)
+	}
+}
+
+func TestRequestProxyRestartWhileRestarting(t *testing.T) {
+	p := &fakeProxy{}
+	defer forgetProxyRestarts([]PeerProxy{p})
+
+	proxyRestarts.mu.Lock()
+	proxyRestarts.entries[p] = &proxyRestartEntry{state: proxyRestarting}
+	proxyRestarts.mu.Unlock()
+
+	requestProxyRestart(p, time.Second)
+	if got := p.restarts.Load(); got != 0 {
+		t.Fatalf("request while already restarting: SignalRestart called %d times, want 0", got)
+	}
+}
+
+func TestForgetProxyRestarts(t *testing.T) {
+	p := &fakeProxy{}
+	requestProxyRestart(p, time.Second)
+
+	proxyRestarts.mu.Lock()
+	_, ok := proxyRestarts.entries[p]
+	proxyRestarts.mu.Unlock()
+	if !ok {
+		t.Fatalf("expected an entry for p after requestProxyRestart")
+	}
+
+	forgetProxyRestarts([]PeerProxy{p})
+
+	proxyRestarts.mu.Lock()
+	_, ok = proxyRestarts.entries[p]
+	proxyRestarts.mu.Unlock()
+	if ok {
+		t.Fatalf("expected forgetProxyRestarts to remove p's entry")
+	}
+}
+
+func TestRouteChangeCoalescerDebouncesBurst(t *testing.T) {
+	var fired atomic.Int32
+	c := newRouteChangeCoalescer(func() { fired.Add(1) })
+	defer c.Close()
+
+	// Simulate a storm of MibNotificationType events, such as a Wi-Fi ->
+	// Ethernet flip producing several route/interface change
+	// notifications in quick succession.
+	for i := 0; i < 10; i++ {
+		c.Notify()
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if got := fired.Load(); got != 0 {
+		t.Fatalf("fire called %d times mid-burst, want 0 before the debounce window elapses", got)
+	}
+
+	// The burst above kept extending the trailing debounce; give it time
+	// to go quiet and fire exactly once.
+	time.Sleep(routeChangeTrailingDebounce + 500*time.Millisecond)
+	if got := fired.Load(); got != 1 {
+		t.Fatalf("fire called %d times after the burst settled, want 1", got)
+	}
+}
+
+func TestRouteChangeCoalescerSingleNotification(t *testing.T) {
+	var fired atomic.Int32
+	c := newRouteChangeCoalescer(func() { fired.Add(1) })
+	defer c.Close()
+
+	c.Notify()
+	time.Sleep(routeChangeLeadingDebounce + 200*time.Millisecond)
+	if got := fired.Load(); got != 1 {
+		t.Fatalf("fire called %d times after a single notification, want 1", got)
+	}
+}
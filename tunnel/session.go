@@ -0,0 +1,95 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2024 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"context"
+	"log"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/tunnel/metrics"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// Session ties together everything this package runs alongside a
+// tunnel's WireGuard device for the life of one connection: peer
+// proxies, the routes that keep them reachable, per-interface/per-peer
+// MTU discovery, and - if the ExperimentalMetricsPort policy is set -
+// the metrics HTTP listener. Close tears all of it down again.
+type Session struct {
+	cancel      context.CancelFunc
+	proxies     []PeerProxy
+	routeCbs    []winipcfg.ChangeCallback
+	mtuMonitor  *MTUMonitor
+	stopMetrics func()
+}
+
+// StartSession spawns the peer proxies conf declares, starts monitoring
+// the routes and interface/peer MTUs they depend on, and starts the
+// opt-in metrics server. ourLUID is the tunnel interface's own LUID, as
+// already required by monitorMTU and monitorProxyRoutes.
+func StartSession(conf *conf.Config, family winipcfg.AddressFamily, ourLUID winipcfg.LUID) (*Session, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Session{cancel: cancel}
+
+	proxies, err := spawnProxies(conf, ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.proxies = proxies
+
+	routeCbs, err := monitorProxyRoutes(family, ourLUID, proxies)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.routeCbs = routeCbs
+
+	mtuMonitor, err := monitorMTU(conf, family, ourLUID)
+	if err != nil {
+		for _, cb := range routeCbs {
+			cb.Unregister()
+		}
+		cancel()
+		return nil, err
+	}
+	s.mtuMonitor = mtuMonitor
+
+	stopMetrics, err := metrics.StartServer()
+	if err != nil {
+		log.Printf("[metrics] Failed to start metrics server: %v", err)
+	} else {
+		s.stopMetrics = stopMetrics
+	}
+
+	return s, nil
+}
+
+// WrapForPMTUD wraps bind and tunDevice so that per-peer Path MTU
+// Discovery observes peer activity and enforces what it discovers - see
+// wrapBindForPMTUD and wrapTUNForPMTUD. The caller constructing the
+// wireguard-go device should pass the returned values to
+// device.NewDevice instead of the unwrapped bind and tunDevice.
+func (s *Session) WrapForPMTUD(bind conn.Bind, tunDevice tun.Device, peers []conf.Peer) (conn.Bind, tun.Device) {
+	return wrapBindForPMTUD(bind, s.mtuMonitor), wrapTUNForPMTUD(tunDevice, s.mtuMonitor, peers)
+}
+
+// Close tears down everything StartSession started.
+func (s *Session) Close() {
+	if s.stopMetrics != nil {
+		s.stopMetrics()
+	}
+	if s.mtuMonitor != nil {
+		s.mtuMonitor.Close()
+	}
+	for _, cb := range s.routeCbs {
+		cb.Unregister()
+	}
+	s.cancel()
+}